@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const defaultCallbackMaxAttempts = 5
+
+// callbackDelivery is one callback's durable state, appended to the queue
+// file on every status change so a restart reloads each callback's last
+// recorded state and the admin endpoint can list/replay it. Pending
+// deliveries are not resumed automatically after a restart; only a replay
+// through the admin endpoint sends them again. Attempt, Status, LastStatus,
+// LastError, and NextAttempt are mutated from the delivery goroutine
+// while the admin endpoint can read them concurrently, so every access
+// to those fields must go through mu.
+type callbackDelivery struct {
+	ID          string    `json:"id"`
+	RequestID   string    `json:"request_id"`
+	URL         string    `json:"url"`
+	Payload     []byte    `json:"payload"`
+	Secret      string    `json:"secret,omitempty"`
+	Attempt     int       `json:"attempt"`
+	MaxAttempts int       `json:"max_attempts"`
+	Status      string    `json:"status"` // pending, delivered, failed
+	LastStatus  int       `json:"last_status,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+
+	mu         sync.Mutex
+	delivering bool
+}
+
+// callbackDeliverer persists callbacks to an append-only JSON log and
+// delivers them with exponential backoff, HMAC signing, and an
+// in-memory index the admin endpoint reads from. Neither the log nor the
+// index is size-limited or compacted: every status change appends a
+// record, and entries are never removed.
+type callbackDeliverer struct {
+	mu            sync.Mutex
+	path          string
+	defaultMaxTry int
+	entries       map[string]*callbackDelivery
+	client        *http.Client
+}
+
+func newCallbackDeliverer(cfg CallbackQueueConfig) (*callbackDeliverer, error) {
+	path := cfg.Path
+	if path == "" {
+		path = "callbacks.log"
+	}
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultCallbackMaxAttempts
+	}
+
+	d := &callbackDeliverer{
+		path:          path,
+		defaultMaxTry: maxAttempts,
+		entries:       map[string]*callbackDelivery{},
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// load replays the append-only queue file, keeping the last record per ID
+// so it reflects each callback's current state.
+func (d *callbackDeliverer) load() error {
+	f, err := os.Open(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		rec := &callbackDelivery{}
+		if err := dec.Decode(rec); err != nil {
+			break
+		}
+		d.entries[rec.ID] = rec
+	}
+
+	return nil
+}
+
+func (d *callbackDeliverer) persist(rec *callbackDelivery) error {
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return json.NewEncoder(f).Encode(rec)
+}
+
+// enqueue records a new callback as pending and returns it for delivery.
+func (d *callbackDeliverer) enqueue(requestID, url string, payload []byte, secret string, maxAttempts int) *callbackDelivery {
+	if maxAttempts <= 0 {
+		maxAttempts = d.defaultMaxTry
+	}
+
+	rec := &callbackDelivery{
+		ID:          requestID,
+		RequestID:   requestID,
+		URL:         url,
+		Payload:     payload,
+		Secret:      secret,
+		MaxAttempts: maxAttempts,
+		Status:      "pending",
+	}
+
+	d.mu.Lock()
+	d.entries[rec.ID] = rec
+	d.mu.Unlock()
+
+	d.persist(rec)
+
+	return rec
+}
+
+// deliver attempts rec until it succeeds or exhausts MaxAttempts,
+// sleeping between attempts with exponential backoff and jitter. It's a
+// no-op if a delivery loop for rec is already running, so replaying a
+// callback that's mid-retry can't start a second concurrent sender.
+func (d *callbackDeliverer) deliver(r *http.Request, rec *callbackDelivery) {
+	rec.mu.Lock()
+	if rec.delivering {
+		rec.mu.Unlock()
+		return
+	}
+	rec.delivering = true
+	rec.mu.Unlock()
+	defer func() {
+		rec.mu.Lock()
+		rec.delivering = false
+		rec.mu.Unlock()
+	}()
+
+	for {
+		rec.mu.Lock()
+		rec.Attempt++
+		attempt, maxAttempts := rec.Attempt, rec.MaxAttempts
+		rec.mu.Unlock()
+
+		attemptStart := time.Now()
+		status, err := d.attempt(rec)
+		latency := time.Since(attemptStart)
+
+		rec.mu.Lock()
+		rec.LastStatus = status
+		if err != nil {
+			rec.LastError = err.Error()
+		} else {
+			rec.LastError = ""
+		}
+		lastErr := rec.LastError
+		rec.mu.Unlock()
+
+		hlog.FromRequest(r).Info().
+			Str("callback_id", rec.ID).
+			Str("callback_url", rec.URL).
+			Int("attempt", attempt).
+			Int("status", status).
+			Str("error", lastErr).
+			Dur("latency", latency).
+			Msg("callback delivery attempt")
+
+		if err == nil && status >= 200 && status < 300 {
+			rec.mu.Lock()
+			rec.Status = "delivered"
+			rec.mu.Unlock()
+			d.persist(rec)
+			return
+		}
+
+		if attempt >= maxAttempts {
+			rec.mu.Lock()
+			rec.Status = "failed"
+			rec.mu.Unlock()
+			d.persist(rec)
+			return
+		}
+
+		backoff := callbackBackoff(attempt)
+		rec.mu.Lock()
+		rec.NextAttempt = time.Now().Add(backoff)
+		rec.mu.Unlock()
+		d.persist(rec)
+
+		time.Sleep(backoff)
+	}
+}
+
+// attempt sends a single signed POST for rec and returns the response
+// status code.
+func (d *callbackDeliverer) attempt(rec *callbackDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, rec.URL, bytes.NewReader(rec.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CAS-Request-Id", rec.RequestID)
+	req.Header.Set("X-CAS-Delivery", rec.ID)
+	if rec.Secret != "" {
+		req.Header.Set("X-CAS-Signature", "sha256="+signCallbackPayload(rec.Secret, rec.Payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+func signCallbackPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// callbackBackoff is exponential with jitter: a random delay between half
+// and all of 2^attempt seconds, with that base capped at 30s.
+func callbackBackoff(attempt int) time.Duration {
+	base := time.Second * time.Duration(1<<uint(attempt))
+	if base > 30*time.Second {
+		base = 30 * time.Second
+	}
+	return base/2 + time.Duration(rand.Int63n(int64(base/2)+1))
+}
+
+// adminCallbacksHandler serves /._cas/callbacks: GET lists every known
+// callback, POST?id=<id> replays one that failed.
+func adminCallbacksHandler(d *callbackDeliverer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			d.mu.Lock()
+			recs := make([]*callbackDelivery, 0, len(d.entries))
+			for _, rec := range d.entries {
+				recs = append(recs, rec)
+			}
+			d.mu.Unlock()
+
+			// Snapshot each record's mutable fields under its own lock;
+			// copying *rec directly would copy its mutex and race with
+			// an in-progress deliver(). Secret is deliberately left
+			// out: it's the HMAC key used to sign deliveries, and this
+			// endpoint has no auth of its own.
+			list := make([]callbackDelivery, len(recs))
+			for i, rec := range recs {
+				rec.mu.Lock()
+				list[i] = callbackDelivery{
+					ID:          rec.ID,
+					RequestID:   rec.RequestID,
+					URL:         rec.URL,
+					Payload:     rec.Payload,
+					Attempt:     rec.Attempt,
+					MaxAttempts: rec.MaxAttempts,
+					Status:      rec.Status,
+					LastStatus:  rec.LastStatus,
+					LastError:   rec.LastError,
+					NextAttempt: rec.NextAttempt,
+				}
+				rec.mu.Unlock()
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+		case http.MethodPost:
+			id := r.URL.Query().Get("id")
+
+			d.mu.Lock()
+			rec, ok := d.entries[id]
+			d.mu.Unlock()
+			if !ok {
+				http.Error(w, "callback not found", http.StatusNotFound)
+				return
+			}
+
+			rec.mu.Lock()
+			if rec.delivering {
+				rec.mu.Unlock()
+				http.Error(w, "callback delivery already in progress", http.StatusConflict)
+				return
+			}
+			rec.Attempt = 0
+			rec.Status = "pending"
+			rec.mu.Unlock()
+
+			go d.deliver(r, rec)
+
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCallbackDeliverySigned(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "callbacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	var gotSig string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSig = r.Header.Get("X-CAS-Signature")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	d, err := newCallbackDeliverer(CallbackQueueConfig{Path: tmp.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/hello", nil)
+	rec := d.enqueue("req-1", target.URL, []byte(`{"ok":true}`), "s3cr3t", 3)
+	d.deliver(req, rec)
+
+	if rec.Status != "delivered" {
+		t.Fatalf("Expect status delivered, but got %q (err=%s)", rec.Status, rec.LastError)
+	}
+	if gotSig == "" {
+		t.Fatal("Expect X-CAS-Signature header to be set")
+	}
+}
+
+func TestCallbackReplayRejectsWhileInFlight(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "callbacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	d, err := newCallbackDeliverer(CallbackQueueConfig{Path: tmp.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := d.enqueue("req-1", "http://example.invalid", []byte(`{}`), "", 1)
+	rec.mu.Lock()
+	rec.delivering = true
+	rec.mu.Unlock()
+
+	server := httptest.NewServer(adminCallbacksHandler(d))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"?id=req-1", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, resp, http.StatusConflict)
+}
+
+func TestCallbackAdminList(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "callbacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	d, err := newCallbackDeliverer(CallbackQueueConfig{Path: tmp.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.enqueue("req-1", "http://example.invalid", []byte(`{}`), "", 1)
+
+	server := httptest.NewServer(adminCallbacksHandler(d))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, resp, 200)
+}
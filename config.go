@@ -0,0 +1,171 @@
+package main
+
+// Config is the top-level shape of the YAML config file: a `log` section
+// controlling where operational/audit logs go, a `callbacks` section
+// controlling durable callback delivery, plus the route table keyed by
+// URL path.
+type Config struct {
+	Log       LogConfig           `yaml:"log,omitempty"`
+	Callbacks CallbackQueueConfig `yaml:"callbacks,omitempty"`
+	// MaxBodyBytes caps the size of a request body across all routes,
+	// guarded by http.MaxBytesReader. Zero means unbounded.
+	MaxBodyBytes int64 `yaml:"max_body_bytes,omitempty"`
+	// ShutdownTimeout bounds how long graceful shutdown waits for
+	// in-flight commands to finish, e.g. "30s". Defaults to 30s.
+	ShutdownTimeout string `yaml:"shutdown_timeout,omitempty"`
+	// TrustedProxies lists peer IPs allowed to set X-Forwarded-For for
+	// per-IP rate limiting (see RateConfig.PerIP). Requests from any
+	// other peer have that header ignored.
+	TrustedProxies []string       `yaml:"trusted_proxies,omitempty,flow"`
+	Routes         map[string]Cmd `yaml:",inline"`
+}
+
+// CallbackQueueConfig configures the durable on-disk queue callbacks are
+// persisted to before delivery, so a restart keeps each callback's last
+// recorded state. Pending deliveries are not resumed automatically after
+// a restart; they can be replayed through the admin endpoint.
+type CallbackQueueConfig struct {
+	// Path is the append-only JSON log callbacks are persisted to.
+	// Defaults to "callbacks.log".
+	Path string `yaml:"path,omitempty"`
+	// MaxAttempts is the default retry ceiling for callbacks that don't
+	// set their own. Defaults to 5.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+}
+
+// LogConfig controls the operational log sink and the separate audit
+// stream used to record every command invocation.
+type LogConfig struct {
+	// Sink is one of "stdout", "stderr", "file", "syslog", or "webhook".
+	// Falls back to the -l flag value when empty.
+	Sink string `yaml:"sink,omitempty"`
+	// Path is the file path to write to when Sink is "file".
+	Path string `yaml:"path,omitempty"`
+	// URL is the endpoint to POST log lines to when Sink is "webhook".
+	URL string `yaml:"url,omitempty"`
+	// Format is "console" (pretty) or "json". Defaults to "json".
+	Format string `yaml:"format,omitempty"`
+	// Level is a zerolog level name, e.g. "debug", "info", "warn", "error".
+	Level string `yaml:"level,omitempty"`
+	// Audit configures the dedicated audit.log stream.
+	Audit AuditConfig `yaml:"audit,omitempty"`
+}
+
+// AuditConfig controls the dedicated audit stream, which records a
+// structured entry for every command invocation so it can be shipped to a
+// SIEM independently of operational logs.
+type AuditConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+}
+
+type Param struct {
+	Dir      string   `json:"dir,omitempty"`
+	Stdin    string   `json:"stdin,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	Envs     []string `json:"envs,omitempty"`
+	Callback string   `json:"callback,omitempty"`
+}
+
+type Cmd struct {
+	Command string   `yaml:"command"`
+	Dir     string   `yaml:"dir,omitempty"`
+	Allow   []string `yaml:"allow,omitempty,flow"`
+	Stdin   string   `yaml:"stdin,omitempty"`
+	Args    []string `yaml:"args,omitempty,flow"`
+	Envs    []string `yaml:"envs,omitempty,flow"`
+	// Schema further constrains whichever of args/envs/dir/stdin Allow
+	// lets through. Leaving a field nil means "allowed but unconstrained".
+	Schema ParamSchema `yaml:"schema,omitempty"`
+	// Stream pipes stdout/stderr to the client as they are produced
+	// instead of buffering the whole command before responding. Clients
+	// can also opt in per-request with an Accept: text/event-stream or
+	// application/x-ndjson header.
+	Stream bool `yaml:"stream,omitempty"`
+	// Methods lists the HTTP methods this route accepts. Defaults to
+	// POST-only when empty, matching cas's original behavior.
+	Methods []string `yaml:"methods,omitempty,flow"`
+	// Secret HMAC-signs callback deliveries for this route; see
+	// CallbackQueueConfig for the shared delivery settings.
+	Secret string `yaml:"secret,omitempty"`
+	// MaxAttempts overrides CallbackQueueConfig.MaxAttempts for this
+	// route's callbacks.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// Timeout bounds how long a single invocation may run, e.g. "30s".
+	// The whole process group is killed on expiry so children don't leak.
+	Timeout string `yaml:"timeout,omitempty"`
+	// MaxConcurrent caps in-flight executions of this route. Zero means
+	// unbounded.
+	MaxConcurrent int `yaml:"max_concurrent,omitempty"`
+	// Rate token-bucket limits requests to this route.
+	Rate RateConfig `yaml:"rate,omitempty"`
+}
+
+// RateConfig configures a token-bucket limiter for a route.
+type RateConfig struct {
+	RPS float64 `yaml:"rps,omitempty"`
+	// Burst is the bucket size. Defaults to 1 when RPS is set.
+	Burst int `yaml:"burst,omitempty"`
+	// PerIP keys the limiter by caller IP instead of sharing one bucket
+	// across every client.
+	PerIP bool `yaml:"per_ip,omitempty"`
+}
+
+func (c Cmd) isAllowed(key string) bool {
+	for _, v := range c.Allow {
+		if v == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ParamSchema holds the per-parameter validation rules for a Cmd, layered
+// on top of the coarse Allow list.
+type ParamSchema struct {
+	Args  *ArgSchema   `yaml:"args,omitempty"`
+	Envs  *EnvSchema   `yaml:"envs,omitempty"`
+	Dir   *ValueSchema `yaml:"dir,omitempty"`
+	Stdin *ValueSchema `yaml:"stdin,omitempty"`
+}
+
+// ArgSchema constrains the `args` request param.
+type ArgSchema struct {
+	MaxCount  int      `yaml:"max_count,omitempty"`
+	MaxLength int      `yaml:"max_length,omitempty"`
+	Enum      []string `yaml:"enum,omitempty"`
+	// Pattern is an RE2 regex applied to every arg. Patterns, if given,
+	// overrides it position by position.
+	Pattern  string   `yaml:"pattern,omitempty"`
+	Patterns []string `yaml:"patterns,omitempty,flow"`
+}
+
+// EnvSchema constrains the `envs` request param, whose entries are
+// "NAME=VALUE" strings.
+type EnvSchema struct {
+	MaxCount  int      `yaml:"max_count,omitempty"`
+	MaxLength int      `yaml:"max_length,omitempty"`
+	Enum      []string `yaml:"enum,omitempty"`
+	Pattern   string   `yaml:"pattern,omitempty"`
+	// Names whitelists which variable names may be set at all.
+	Names []string `yaml:"names,omitempty,flow"`
+}
+
+// ValueSchema constrains a single scalar request param (`dir` or `stdin`).
+type ValueSchema struct {
+	MaxLength int      `yaml:"max_length,omitempty"`
+	Enum      []string `yaml:"enum,omitempty"`
+	Pattern   string   `yaml:"pattern,omitempty"`
+}
+
+type Result struct {
+	RequestID string `json:"request_id"`
+	Output    string `json:"output"`
+	Error     string `json:"error"`
+	Status    int    `json:"status"`
+}
+
+type Callback struct {
+	RequestID string `json:"request_id"`
+	URL       string `json:"url"`
+}
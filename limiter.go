@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// buildLimiters builds a fresh routeLimiter for every route in routes.
+// It's bundled into the same routeTable as the routes it was built from
+// (see routeTable in main.go) rather than kept as a shared package
+// global, so two independent route tables (e.g. two tests, or a SIGHUP
+// reload swapping in a new one) never leak each other's limiter state
+// for a path they happen to share.
+func buildLimiters(routes map[string]Cmd) map[string]*routeLimiter {
+	m := make(map[string]*routeLimiter, len(routes))
+	for pattern, c := range routes {
+		m[pattern] = newRouteLimiter(c)
+	}
+	return m
+}
+
+// routeLimiterFor looks up the limiter for pattern, falling back to an
+// unlimited one if it's somehow missing.
+func routeLimiterFor(limiters map[string]*routeLimiter, pattern string) *routeLimiter {
+	if rl, ok := limiters[pattern]; ok {
+		return rl
+	}
+	return &routeLimiter{}
+}
+
+// trustedProxies holds the set of peer IPs allowed to set
+// X-Forwarded-For for per-IP rate limiting. It's an atomic pointer so a
+// SIGHUP reload can swap it without a lock.
+var trustedProxies atomic.Pointer[map[string]struct{}]
+
+// setTrustedProxies publishes the trusted-proxy allowlist from config.
+func setTrustedProxies(ips []string) {
+	m := make(map[string]struct{}, len(ips))
+	for _, ip := range ips {
+		m[ip] = struct{}{}
+	}
+	trustedProxies.Store(&m)
+}
+
+func isTrustedProxy(ip string) bool {
+	m := trustedProxies.Load()
+	if m == nil {
+		return false
+	}
+	_, ok := (*m)[ip]
+	return ok
+}
+
+// routeLimiter holds the live concurrency and rate-limiting state for one
+// route. Cmd values are copied around (map lookups, merges with request
+// params), so this state can't live on Cmd itself — it's built alongside
+// each route table (see buildLimiters) and looked up by route pattern.
+type routeLimiter struct {
+	sem   chan struct{}
+	rps   float64
+	burst int
+	perIP bool
+
+	mu      sync.Mutex
+	bucket  *tokenBucket
+	perIPTB map[string]*tokenBucket
+}
+
+func newRouteLimiter(c Cmd) *routeLimiter {
+	rl := &routeLimiter{rps: c.Rate.RPS, burst: c.Rate.Burst, perIP: c.Rate.PerIP}
+
+	if c.MaxConcurrent > 0 {
+		rl.sem = make(chan struct{}, c.MaxConcurrent)
+	}
+	if rl.rps > 0 {
+		if rl.perIP {
+			rl.perIPTB = map[string]*tokenBucket{}
+		} else {
+			rl.bucket = newTokenBucket(rl.rps, rl.burst)
+		}
+	}
+
+	return rl
+}
+
+// allow reports whether r may proceed under the route's rate limit, and
+// if not, how long the client should wait before retrying.
+func (rl *routeLimiter) allow(r *http.Request) (bool, time.Duration) {
+	if rl.rps <= 0 {
+		return true, 0
+	}
+
+	bucket := rl.bucket
+	if rl.perIP {
+		ip := clientIP(r)
+		rl.mu.Lock()
+		bucket = rl.perIPTB[ip]
+		if bucket == nil {
+			if len(rl.perIPTB) >= maxPerIPBuckets {
+				evictStalePerIPBuckets(rl.perIPTB)
+			}
+			bucket = newTokenBucket(rl.rps, rl.burst)
+			rl.perIPTB[ip] = bucket
+		}
+		rl.mu.Unlock()
+	}
+
+	return bucket.allow()
+}
+
+// maxPerIPBuckets bounds how many per-IP token buckets a route can hold,
+// so spoofing X-Forwarded-For with random values can't grow perIPTB
+// without limit and exhaust memory.
+const maxPerIPBuckets = 4096
+
+// perIPBucketTTL is how long an idle per-IP bucket is kept before it's
+// eligible for eviction.
+const perIPBucketTTL = 10 * time.Minute
+
+// evictStalePerIPBuckets drops buckets idle longer than perIPBucketTTL,
+// and if the map is still at capacity afterwards, evicts the single
+// least-recently-used bucket. Called with rl.mu already held.
+func evictStalePerIPBuckets(m map[string]*tokenBucket) {
+	now := time.Now()
+	for ip, b := range m {
+		b.mu.Lock()
+		stale := now.Sub(b.last) > perIPBucketTTL
+		b.mu.Unlock()
+		if stale {
+			delete(m, ip)
+		}
+	}
+
+	if len(m) < maxPerIPBuckets {
+		return
+	}
+
+	var oldestIP string
+	var oldest time.Time
+	for ip, b := range m {
+		b.mu.Lock()
+		last := b.last
+		b.mu.Unlock()
+		if oldestIP == "" || last.Before(oldest) {
+			oldestIP, oldest = ip, last
+		}
+	}
+	delete(m, oldestIP)
+}
+
+// acquire blocks until a concurrency slot is free, or ctx is done.
+func (rl *routeLimiter) acquire(ctx context.Context) bool {
+	if rl.sem == nil {
+		return true
+	}
+	select {
+	case rl.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (rl *routeLimiter) release() {
+	if rl.sem != nil {
+		<-rl.sem
+	}
+}
+
+// clientIP returns the key used for per-IP rate limiting. X-Forwarded-For
+// is only trusted when the direct peer is in the configured
+// trusted_proxies list; otherwise any client could bypass per-IP limits
+// by sending an arbitrary value for that header.
+func clientIP(r *http.Request) string {
+	host := r.RemoteAddr
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		host = host[:i]
+	}
+
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" && isTrustedProxy(host) {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+
+	return host
+}
+
+// tokenBucket is a minimal token-bucket rate limiter: tokens refill
+// continuously at `rate` per second up to `burst`, and allow() takes one
+// when available.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rps float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if b <= 0 {
+		b = 1
+	}
+	return &tokenBucket{rate: rps, burst: b, tokens: b, last: time.Now()}
+}
+
+func (b *tokenBucket) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
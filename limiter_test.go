@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTimeoutKillsCommand(t *testing.T) {
+	config := map[string]Cmd{
+		"/slow": Cmd{
+			Command: "sleep",
+			Args:    []string{"5"},
+			Timeout: "50ms",
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp := req(t, server.URL+"/slow", nil)
+	expectStatus(t, resp, 504)
+}
+
+func TestRateLimitRejects(t *testing.T) {
+	config := map[string]Cmd{
+		"/hello": Cmd{
+			Command: "echo",
+			Args:    []string{"hi"},
+			Rate:    RateConfig{RPS: 1, Burst: 1},
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp := req(t, server.URL+"/hello", bytes.NewBuffer(nil))
+	expectStatus(t, resp, 200)
+
+	resp = req(t, server.URL+"/hello", bytes.NewBuffer(nil))
+	expectStatus(t, resp, 429)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("Expect Retry-After header on 429")
+	}
+}
+
+// TestRateLimitScopedPerTable guards against limiters leaking between
+// unrelated route tables that happen to share a path: a second table
+// for the same pattern with no rate limit configured must not inherit
+// an exhausted bucket from a previous one.
+func TestRateLimitScopedPerTable(t *testing.T) {
+	limited := map[string]Cmd{
+		"/hello": Cmd{
+			Command: "echo",
+			Args:    []string{"hi"},
+			Rate:    RateConfig{RPS: 1, Burst: 1},
+		},
+	}
+	limitedServer := httptest.NewServer(handlerFunc(limited))
+	defer limitedServer.Close()
+
+	resp := req(t, limitedServer.URL+"/hello", bytes.NewBuffer(nil))
+	expectStatus(t, resp, 200)
+	resp = req(t, limitedServer.URL+"/hello", bytes.NewBuffer(nil))
+	expectStatus(t, resp, 429)
+
+	unlimited := map[string]Cmd{
+		"/hello": Cmd{
+			Command: "echo",
+			Args:    []string{"hi"},
+		},
+	}
+	unlimitedServer := httptest.NewServer(handlerFunc(unlimited))
+	defer unlimitedServer.Close()
+
+	resp = req(t, unlimitedServer.URL+"/hello", bytes.NewBuffer(nil))
+	expectStatus(t, resp, 200)
+}
+
+func TestClientIPIgnoresUntrustedForwardedFor(t *testing.T) {
+	setTrustedProxies(nil)
+	defer setTrustedProxies(nil)
+
+	r := &http.Request{
+		RemoteAddr: "203.0.113.5:1234",
+		Header:     http.Header{"X-Forwarded-For": []string{"1.2.3.4"}},
+	}
+	if ip := clientIP(r); ip != "203.0.113.5" {
+		t.Fatalf("expected untrusted peer's X-Forwarded-For to be ignored, got %q", ip)
+	}
+
+	setTrustedProxies([]string{"203.0.113.5"})
+	if ip := clientIP(r); ip != "1.2.3.4" {
+		t.Fatalf("expected X-Forwarded-For to be trusted from an allowed proxy, got %q", ip)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newLogger builds the operational logger described by cfg, falling back
+// to legacySink (the -l flag value) when cfg.Sink is empty so existing
+// configs without a `log:` section keep working. legacySink is either
+// "stdout", "stderr", "" (discard), or a file path.
+func newLogger(cfg LogConfig, legacySink string) (zerolog.Logger, error) {
+	var (
+		w   io.Writer
+		err error
+	)
+
+	if cfg.Sink != "" {
+		w, err = logWriter(cfg.Sink, cfg)
+	} else {
+		w, err = legacyLogWriter(legacySink)
+	}
+	if err != nil {
+		return zerolog.Logger{}, err
+	}
+
+	if cfg.Format == "console" {
+		w = zerolog.ConsoleWriter{Out: w}
+	}
+
+	logger := zerolog.New(w).With().Timestamp().Logger()
+	if cfg.Level != "" {
+		lvl, err := zerolog.ParseLevel(cfg.Level)
+		if err != nil {
+			return zerolog.Logger{}, fmt.Errorf("log: %s", err)
+		}
+		logger = logger.Level(lvl)
+	}
+
+	return logger, nil
+}
+
+// logWriter resolves a `log.sink` config value to its io.Writer.
+func logWriter(sink string, cfg LogConfig) (io.Writer, error) {
+	switch sink {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("log: file sink requires a path")
+		}
+		return os.OpenFile(cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	case "syslog":
+		return syslog.New(syslog.LOG_INFO, "cas")
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("log: webhook sink requires a url")
+		}
+		return &webhookWriter{url: cfg.URL, client: &http.Client{Timeout: 5 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("log: unknown sink %q", sink)
+	}
+}
+
+// legacyLogWriter resolves the -l flag value: "stdout", "stderr", ""
+// (discard), or a file path that operational logs are appended to. The
+// file is not rotated.
+func legacyLogWriter(sink string) (io.Writer, error) {
+	switch sink {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	case "":
+		return ioutil.Discard, nil
+	default:
+		return os.OpenFile(sink, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	}
+}
+
+// webhookWriter POSTs each log line to a configured HTTP endpoint, letting
+// operators ship logs straight to a collector without a local sidecar.
+type webhookWriter struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhookWriter) Write(p []byte) (int, error) {
+	resp, err := w.client.Post(w.url, "application/x-ndjson", bytes.NewReader(p))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return len(p), nil
+}
+
+// newAuditLogger builds the dedicated audit.log stream, kept separate from
+// operational logs so security teams can ship it to a SIEM on its own. It
+// is a no-op sink when auditing is disabled.
+func newAuditLogger(cfg AuditConfig) (zerolog.Logger, error) {
+	if !cfg.Enabled {
+		return zerolog.New(ioutil.Discard), nil
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = "audit.log"
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return zerolog.Logger{}, fmt.Errorf("audit: %s", err)
+	}
+
+	return zerolog.New(f).With().Timestamp().Logger(), nil
+}
+
+// auditInvocation records a single command execution to the audit stream:
+// the resolved command, its merged args/env, exit code, duration, output
+// sizes, caller IP, and request ID.
+func auditInvocation(logger zerolog.Logger, r *http.Request, c Cmd, res runResult) {
+	logger.Info().
+		Str("request_id", requestId(r)).
+		Str("ip", r.RemoteAddr).
+		Str("command", c.Command).
+		Strs("args", c.Args).
+		Strs("envs", c.Envs).
+		Int("exit_code", res.ExitCode).
+		Dur("duration", res.Duration).
+		Int("stdout_bytes", len(res.Stdout)).
+		Int("stderr_bytes", len(res.Stderr)).
+		Msg("command executed")
+}
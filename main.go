@@ -13,6 +13,9 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/justinas/alice"
 	"github.com/rs/zerolog"
@@ -20,44 +23,6 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-type Param struct {
-	Dir      string   `json:"dir,omitempty"`
-	Stdin    string   `json:"stdin,omitempty"`
-	Args     []string `json:"args,omitempty"`
-	Envs     []string `json:"envs,omitempty"`
-	Callback string   `json:"callback,omitempty"`
-}
-
-type Cmd struct {
-	Command string   `yaml:"command"`
-	Dir     string   `yaml:"dir,omitempty"`
-	Allow   []string `yaml:"allow,omitempty,flow"`
-	Stdin   string   `yaml:"stdin,omitempty"`
-	Args    []string `yaml:"args,omitempty,flow"`
-	Envs    []string `yaml:"envs,omitempty,flow"`
-}
-
-func (c Cmd) isAllowed(key string) bool {
-	for _, v := range c.Allow {
-		if v == key {
-			return true
-		}
-	}
-	return false
-}
-
-type Result struct {
-	RequestID string `json:"request_id"`
-	Output    string `json:"output"`
-	Error     string `json:"error"`
-	Status    int    `json:"status"`
-}
-
-type Callback struct {
-	RequestID string `json:"request_id"`
-	URL       string `json:"url"`
-}
-
 var (
 	configFile = flag.String("c", "./config.yml", "")
 	logTo      = flag.String("l", "stdout", "")
@@ -68,11 +33,17 @@ var usage = `Usage: cas [options...]
 
 Options:
   -c  Config file. Default to ./config.yml
-  -l  Write log to either stdout, stderr, or file. Default to stdout.
+  -l  Write log to stdout, stderr, or a file path. Default to stdout.
+      Overridden by the config file's "log.sink" when set.
   -p  Port to listen. Defalut to 1307.
 `
 
-var logger zerolog.Logger
+var (
+	logger       zerolog.Logger
+	auditLogger  zerolog.Logger
+	deliverer    *callbackDeliverer
+	maxBodyBytes int64
+)
 
 func main() {
 	flag.Usage = func() {
@@ -80,31 +51,36 @@ func main() {
 	}
 	flag.Parse()
 
-	switch *logTo {
-	case "stdout":
-		logger = zerolog.New(os.Stdout)
-	case "stderr":
-		logger = zerolog.New(os.Stderr)
-	case "":
-		logger = zerolog.New(ioutil.Discard)
-	default:
-		// TODO: log to file.
-	}
-
-	logger.With().Timestamp()
-
 	content, err := ioutil.ReadFile(*configFile)
 	if err != nil {
 		fail(fmt.Sprintf("Failed to read config file: %s", err))
 	}
 
-	var config map[string]Cmd
+	var config Config
 	if err = yaml.Unmarshal(content, &config); err != nil {
 		fail(fmt.Sprintf("Failed to parse config: %s", err))
 	}
 
-	http.Handle("/", handlerFunc(config))
-	http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
+	logger, err = newLogger(config.Log, *logTo)
+	if err != nil {
+		fail(fmt.Sprintf("Failed to set up logger: %s", err))
+	}
+
+	auditLogger, err = newAuditLogger(config.Log.Audit)
+	if err != nil {
+		fail(fmt.Sprintf("Failed to set up audit logger: %s", err))
+	}
+
+	deliverer, err = newCallbackDeliverer(config.Callbacks)
+	if err != nil {
+		fail(fmt.Sprintf("Failed to set up callback queue: %s", err))
+	}
+
+	maxBodyBytes = config.MaxBodyBytes
+
+	if err := runServer(*configFile, config); err != nil {
+		fail(fmt.Sprintf("Server error: %s", err))
+	}
 }
 
 func fail(msg string) {
@@ -112,10 +88,36 @@ func fail(msg string) {
 	os.Exit(1)
 }
 
+// routeTable bundles a route map with the routeLimiters built for it.
+// The two are always swapped together: routeLimiterFor keys purely off
+// the route pattern string, so looking it up against a limiters map
+// built for a different table could return another table's rate-limit
+// or concurrency state for a path they both happen to use. Bundling
+// them means a fresh table (a new handlerFunc call, or a SIGHUP reload)
+// always carries its own limiters.
+type routeTable struct {
+	routes   map[string]Cmd
+	limiters map[string]*routeLimiter
+}
+
+func newRouteTable(routes map[string]Cmd) *routeTable {
+	return &routeTable{routes: routes, limiters: buildLimiters(routes)}
+}
+
+// handlerFunc builds the route handler for a fixed, never-reloaded route
+// table. Used directly by tests; production serving goes through
+// handlerFuncRoutes so SIGHUP can hot-swap the table.
 func handlerFunc(config map[string]Cmd) http.Handler {
-	// Middleware.
+	table := &atomic.Pointer[routeTable]{}
+	table.Store(newRouteTable(config))
+	return handlerFuncRoutes(table)
+}
+
+// handlerFuncRoutes builds the route handler from a live route table,
+// re-read on every request so a SIGHUP reload takes effect immediately.
+func handlerFuncRoutes(table *atomic.Pointer[routeTable]) http.Handler {
 	m := alice.New()
-	m = m.Append(setConfig(config))
+	m = m.Append(setConfig(table))
 	m = m.Append(hlog.NewHandler(logger))
 	m = m.Append(hlog.RequestIDHandler("request_id", "Request-Id"))
 	m = m.Append(hlog.RequestHandler("request"))
@@ -125,27 +127,37 @@ func handlerFunc(config map[string]Cmd) http.Handler {
 	return m.Then(http.HandlerFunc(handle))
 }
 
-// setConfig is a middleware that sets config in request's context.
-func setConfig(config map[string]Cmd) func(next http.Handler) http.Handler {
+// setConfig is a middleware that sets the current route table in the
+// request's context. In-flight requests keep the snapshot they read here
+// even if a reload swaps routes afterward.
+func setConfig(table *atomic.Pointer[routeTable]) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := context.WithValue(r.Context(), "config", config)
+			ctx := context.WithValue(r.Context(), "config", table.Load())
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
 func handle(w http.ResponseWriter, r *http.Request) {
-	if _, ok := requestConfig(w, r); !ok {
+	table, ok := requestConfig(w, r)
+	if !ok {
 		return
 	}
 
-	if !requestMethodAllowed(w, r) {
+	c, pattern, pathParams, ok := requestCmd(w, r)
+	if !ok {
 		return
 	}
 
-	c, ok := requestCmd(w, r)
-	if !ok {
+	if !requestMethodAllowed(w, r, c) {
+		return
+	}
+
+	rl := routeLimiterFor(table.limiters, pattern)
+	if allowed, retryAfter := rl.allow(r); !allowed {
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", retryAfter.Seconds()))
+		handleError(w, r, errors.New("rate limit exceeded"), http.StatusTooManyRequests)
 		return
 	}
 
@@ -154,6 +166,17 @@ func handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	c, err := bindCmdParams(c, r, pathParams)
+	if err != nil {
+		status := http.StatusBadRequest
+		var se errSchema
+		if errors.As(err, &se) {
+			status = http.StatusForbidden
+		}
+		handleError(w, r, err, status)
+		return
+	}
+
 	c.Args = append(c.Args, p.Args...)
 	c.Envs = append(c.Envs, p.Envs...)
 	if p.Stdin != "" {
@@ -163,23 +186,69 @@ func handle(w http.ResponseWriter, r *http.Request) {
 		c.Dir = p.Dir
 	}
 
-	if callback(w, r, c, p) {
+	// Callback mode hands the command off to a detached goroutine and
+	// responds immediately, so max_concurrent and c.Timeout have to be
+	// enforced around that goroutine's own run() call instead of here
+	// — acquiring/releasing the slot around this synchronous handler
+	// would free it before the command actually starts.
+	if callback(w, r, c, p, rl) {
+		return
+	}
+
+	ctx, cancel, err := cmdContext(r.Context(), c)
+	if err != nil {
+		handleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer cancel()
+
+	if !rl.acquire(ctx) {
+		handleError(w, r, errors.New("gateway timeout waiting for a free concurrency slot"), http.StatusGatewayTimeout)
+		return
+	}
+	defer rl.release()
+
+	r = r.WithContext(ctx)
+
+	if wantsStream(r, c) {
+		streamResult(w, r, c)
 		return
 	}
 
 	result := Result{
 		RequestID: requestId(r),
 	}
-	output, err := run(c)
+	res, err := run(ctx, c)
+	if errors.Is(err, context.DeadlineExceeded) {
+		handleError(w, r, errors.New("command timed out"), http.StatusGatewayTimeout)
+		return
+	}
 	if err != nil {
 		result.Error = err.Error()
 	}
-	result.Output = string(output)
+	result.Output = string(append(res.Stdout, res.Stderr...))
 	result.Status = http.StatusOK
 
+	auditInvocation(auditLogger, r, c, res)
 	jsonResult(w, r, result)
 }
 
+// cmdContext derives a context bounded by c.Timeout, if configured.
+// cancel is always non-nil and safe to defer unconditionally.
+func cmdContext(parent context.Context, c Cmd) (ctx context.Context, cancel context.CancelFunc, err error) {
+	if c.Timeout == "" {
+		return parent, func() {}, nil
+	}
+
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return parent, func() {}, fmt.Errorf("invalid timeout: %s", err)
+	}
+
+	ctx, cancel = context.WithTimeout(parent, d)
+	return ctx, cancel, nil
+}
+
 func handleError(w http.ResponseWriter, r *http.Request, err error, status int) {
 	result := Result{
 		RequestID: requestId(r),
@@ -198,44 +267,71 @@ func jsonResult(w http.ResponseWriter, r *http.Request, result Result) {
 	logResult(r, result)
 }
 
-func requestConfig(w http.ResponseWriter, r *http.Request) (config map[string]Cmd, ok bool) {
-	if c := r.Context().Value("config"); c != nil {
-		config, ok = c.(map[string]Cmd)
-	}
+func requestConfig(w http.ResponseWriter, r *http.Request) (table *routeTable, ok bool) {
+	table, ok = r.Context().Value("config").(*routeTable)
 	if !ok {
 		handleError(w, r, errors.New("config not found in request context"), http.StatusInternalServerError)
 	}
 
-	return config, ok
+	return table, ok
 }
 
-func requestMethodAllowed(w http.ResponseWriter, r *http.Request) bool {
-	if r.Method == "POST" {
-		return true
+// requestMethodAllowed enforces c.Methods, defaulting to POST-only when a
+// route doesn't declare any, matching cas's original behavior.
+func requestMethodAllowed(w http.ResponseWriter, r *http.Request, c Cmd) bool {
+	methods := c.Methods
+	if len(methods) == 0 {
+		methods = []string{"POST"}
 	}
-	w.Header().Set("Allow", "POST")
+
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
 	handleError(w, r, errors.New("invalid request method"), http.StatusMethodNotAllowed)
 
 	return false
 }
 
-func requestCmd(w http.ResponseWriter, r *http.Request) (c Cmd, ok bool) {
-	config := r.Context().Value("config").(map[string]Cmd)
+// requestCmd resolves the Cmd for this request, first by an exact path
+// match and then against route patterns such as "/build/:project/:ref",
+// returning the matched pattern (the routes map's key) and any captured
+// path variables alongside it.
+func requestCmd(w http.ResponseWriter, r *http.Request) (c Cmd, pattern string, pathParams map[string]string, ok bool) {
+	config := r.Context().Value("config").(*routeTable).routes
 
-	c, ok = config[r.URL.Path]
-	if !ok {
-		handleError(w, r, errors.New("handler not found"), http.StatusNotFound)
+	if c, ok = config[r.URL.Path]; ok {
+		return c, r.URL.Path, nil, true
+	}
+
+	for p, cmd := range config {
+		if params, matched := matchRoute(p, r.URL.Path); matched {
+			return cmd, p, params, true
+		}
 	}
-	return c, ok
+
+	handleError(w, r, errors.New("handler not found"), http.StatusNotFound)
+	return Cmd{}, "", nil, false
 }
 
 func requestParam(w http.ResponseWriter, r *http.Request, c Cmd) (p Param, ok bool) {
+	if maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	}
+
 	err := json.NewDecoder(r.Body).Decode(&p)
 
-	// Empty body.
-	if err == io.EOF {
-		return p, true
-	} else if err != nil {
+	switch {
+	case err == io.EOF:
+		// Empty body: non-POST requests fall back to binding params from
+		// the query string so GET-style routes work without a JSON body.
+		if r.Method != http.MethodPost {
+			p = paramFromQuery(r)
+		}
+	case err != nil:
 		handleError(w, r, err, http.StatusBadRequest)
 		return
 	}
@@ -249,32 +345,12 @@ func requestParam(w http.ResponseWriter, r *http.Request, c Cmd) (p Param, ok bo
 	return p, ok
 }
 
-func checkRequestParam(r *http.Request, c Cmd, p Param) error {
-	if !c.isAllowed("args") && len(p.Args) > 0 {
-		return errors.New("args param is not allowed")
-	}
-	if !c.isAllowed("envs") && len(p.Envs) > 0 {
-		return errors.New("envs param is not allowed")
-	}
-	if !c.isAllowed("stdin") && p.Stdin != "" {
-		return errors.New("stdin param is not allowed")
-	}
-	if !c.isAllowed("dir") && p.Dir != "" {
-		return errors.New("dir param is not allowed")
-	}
-	if !c.isAllowed("callback") && p.Callback != "" {
-		return errors.New("callback param is not allowed")
-	}
-
-	return nil
-}
-
-func callback(w http.ResponseWriter, r *http.Request, c Cmd, p Param) bool {
+func callback(w http.ResponseWriter, r *http.Request, c Cmd, p Param, rl *routeLimiter) bool {
 	if p.Callback == "" {
 		return false
 	}
 
-	go runCallback(r, c, p)
+	go runCallback(r, c, p, rl)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -294,31 +370,45 @@ func callback(w http.ResponseWriter, r *http.Request, c Cmd, p Param) bool {
 	return true
 }
 
-func runCallback(r *http.Request, c Cmd, p Param) {
+func runCallback(r *http.Request, c Cmd, p Param, rl *routeLimiter) {
 	result := Result{
 		RequestID: requestId(r),
 	}
-	output, err := run(c)
+
+	// Use a detached base context: r's is cancelled once handle() returns
+	// the initial response, but this command keeps running in the
+	// background. c.Timeout is still enforced, via cmdContext.
+	ctx, cancel, err := cmdContext(context.Background(), c)
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("invalid timeout")
+		return
+	}
+	defer cancel()
+
+	if !rl.acquire(ctx) {
+		hlog.FromRequest(r).Error().Msg("callback command never acquired a concurrency slot")
+		return
+	}
+	defer rl.release()
+
+	res, err := run(ctx, c)
 	if err != nil {
 		result.Error = err.Error()
 	}
-	result.Output = string(output)
+	result.Output = string(append(res.Stdout, res.Stderr...))
 	result.Status = http.StatusOK
 
+	auditInvocation(auditLogger, r, c, res)
 	logResult(r, result)
 
 	b, err := json.Marshal(result)
-	if err != nil && result.Error == "" {
-		result.Error = err.Error()
-	}
-
-	resp, err := http.Post(p.Callback, "application/json", bytes.NewBuffer(b))
 	if err != nil {
-		hlog.FromRequest(r).Error().Err(err).Msg("")
+		hlog.FromRequest(r).Error().Err(err).Msg("failed to marshal callback payload")
+		return
 	}
-	hlog.FromRequest(r).Info().
-		Str("callback_resp_status", resp.Status).
-		Msg("")
+
+	rec := deliverer.enqueue(requestId(r), p.Callback, b, c.Secret, c.MaxAttempts)
+	deliverer.deliver(r, rec)
 }
 
 func requestId(r *http.Request) string {
@@ -329,16 +419,64 @@ func requestId(r *http.Request) string {
 	return reqId
 }
 
-func run(c Cmd) ([]byte, error) {
+// runResult captures everything about a command invocation worth auditing:
+// its output streams kept separate, exit code, and wall-clock duration.
+type runResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	Duration time.Duration
+}
+
+// run executes c, killing its whole process group if ctx is cancelled or
+// times out so children don't outlive the request.
+func run(ctx context.Context, c Cmd) (runResult, error) {
+	execWG.Add(1)
+	defer execWG.Done()
+
+	var stdout, stderr bytes.Buffer
+
 	cmd := exec.Command(c.Command, c.Args...)
 	cmd.Dir = c.Dir
 	cmd.Env = c.Envs
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	if c.Stdin != "" {
 		cmd.Stdin = strings.NewReader(c.Stdin)
 	}
 
-	return cmd.CombinedOutput()
+	start := time.Now()
+
+	if err := cmd.Start(); err != nil {
+		return runResult{ExitCode: -1, Duration: time.Since(start)}, err
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-waitErr
+		err = ctx.Err()
+	}
+
+	res := runResult{
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		Duration: time.Since(start),
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		res.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		res.ExitCode = -1
+	}
+
+	return res, err
 }
 
 func logResult(r *http.Request, result Result) {
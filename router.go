@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs is the safe function set exposed to Args/Envs/Dir/Stdin
+// templates. Kept deliberately small: string shaping only, nothing that
+// touches the filesystem or network.
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// templateData is what Args/Envs/Dir/Stdin templates see: captured path
+// variables and the request's query parameters, e.g. {{.Path.project}}
+// and {{.Query.branch}}.
+type templateData struct {
+	Path  map[string]string
+	Query map[string]string
+}
+
+// splitPath trims and splits a URL path into its segments.
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// matchRoute matches path against a route pattern such as
+// "/build/:project/:ref", returning the captured path variables.
+func matchRoute(pattern, path string) (map[string]string, bool) {
+	patSegs := splitPath(pattern)
+	pathSegs := splitPath(path)
+	if len(patSegs) != len(pathSegs) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, seg := range patSegs {
+		if strings.HasPrefix(seg, ":") {
+			params[seg[1:]] = pathSegs[i]
+			continue
+		}
+		if seg != pathSegs[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// bindCmdParams renders Args/Envs/Dir/Stdin templates against the
+// captured path variables and the request's query string, e.g.
+// {{.Path.project}} or {{.Query.branch}}. Since path and query values
+// are caller-controlled, every rendered value is checked against the
+// route's Schema just like a body/query Param would be — the Cmd's own
+// literal (non-templated) config values are left alone, since Schema
+// constrains what callers can inject, not what the route operator
+// configured.
+func bindCmdParams(c Cmd, r *http.Request, pathParams map[string]string) (Cmd, error) {
+	if len(pathParams) == 0 && r.URL.RawQuery == "" {
+		return c, nil
+	}
+
+	data := templateData{Path: pathParams, Query: map[string]string{}}
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			data.Query[k] = v[0]
+		}
+	}
+
+	var err error
+	if c.Dir, err = renderAndCheck(c.Dir, data, func(v string) error {
+		return c.Schema.Dir.check("dir", v)
+	}); err != nil {
+		return c, err
+	}
+	if c.Stdin, err = renderAndCheck(c.Stdin, data, func(v string) error {
+		return c.Schema.Stdin.check("stdin", v)
+	}); err != nil {
+		return c, err
+	}
+	// Copy before mutating in place: c.Args/c.Envs alias the route
+	// table's backing arrays even though c itself was copied by value,
+	// so writing through c.Args[i] would permanently bake the first
+	// request's rendered value into the shared route config.
+	args := append([]string(nil), c.Args...)
+	for i, a := range args {
+		if args[i], err = renderAndCheck(a, data, c.Schema.Args.checkValue); err != nil {
+			return c, err
+		}
+	}
+	c.Args = args
+
+	envs := append([]string(nil), c.Envs...)
+	for i, e := range envs {
+		if envs[i], err = renderAndCheck(e, data, c.Schema.Envs.checkValue); err != nil {
+			return c, err
+		}
+	}
+	c.Envs = envs
+
+	return c, nil
+}
+
+// renderAndCheck renders s as a template and, only if s actually
+// contained template syntax (i.e. its value came from the caller, not
+// a static config literal), validates the result with check.
+func renderAndCheck(s string, data templateData, check func(string) error) (string, error) {
+	templated := strings.Contains(s, "{{")
+
+	out, err := renderTemplate(s, data)
+	if err != nil {
+		return out, err
+	}
+	if templated {
+		if err := check(out); err != nil {
+			return out, errSchema{err}
+		}
+	}
+
+	return out, nil
+}
+
+// renderTemplate executes s as a text/template against data, passing it
+// through unchanged when it has no template actions.
+func renderTemplate(s string, data templateData) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	t, err := template.New("").Funcs(templateFuncs).Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// paramFromQuery builds a Param from the request's query string, used
+// for non-POST requests whose body is empty.
+func paramFromQuery(r *http.Request) Param {
+	q := r.URL.Query()
+	return Param{
+		Dir:      q.Get("dir"),
+		Stdin:    q.Get("stdin"),
+		Args:     q["args"],
+		Envs:     q["envs"],
+		Callback: q.Get("callback"),
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func httpGet(t *testing.T, url string) (*http.Response, error) {
+	t.Helper()
+	return http.Get(url)
+}
+
+func TestRoutePathParams(t *testing.T) {
+	config := map[string]Cmd{
+		"/build/:project/:ref": Cmd{
+			Command: "echo",
+			Args:    []string{"{{.Path.project}}@{{.Path.ref}}"},
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp := req(t, server.URL+"/build/cas/main", nil)
+	expectStatus(t, resp, 200)
+	expectResult(t, resp, Result{Output: "cas@main\n", Error: "", Status: 200})
+}
+
+func TestRoutePathParamsEnforcesSchema(t *testing.T) {
+	config := map[string]Cmd{
+		"/build/:project/:ref": Cmd{
+			Command: "echo",
+			Args:    []string{"{{.Path.ref}}"},
+			Schema: ParamSchema{
+				Args: &ArgSchema{Pattern: "^[a-zA-Z0-9]+$"},
+			},
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp := req(t, server.URL+"/build/cas/main", nil)
+	expectStatus(t, resp, 200)
+
+	resp = req(t, server.URL+"/build/cas/$(whoami)", nil)
+	expectStatus(t, resp, 403)
+}
+
+func TestRouteMethodsAndQueryBinding(t *testing.T) {
+	config := map[string]Cmd{
+		"/greet": Cmd{
+			Command: "echo",
+			Allow:   []string{"args"},
+			Methods: []string{"GET"},
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp, err := httpGet(t, server.URL+"/greet?args=hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, resp, 200)
+	expectResult(t, resp, Result{Output: "hello\n", Error: "", Status: 200})
+
+	resp = req(t, server.URL+"/greet", nil)
+	expectStatus(t, resp, 405)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// execWG tracks in-flight command executions (see run()) so graceful
+// shutdown can wait for them to finish instead of killing them mid-run.
+var execWG sync.WaitGroup
+
+// liveRoutes holds the live route table. setConfig reads it on every
+// request so a SIGHUP reload is visible to new requests immediately,
+// while requests already in flight keep the Cmd they captured.
+var liveRoutes atomic.Pointer[routeTable]
+
+// runServer serves cas until SIGINT/SIGTERM, reloading the route table
+// from configFile on SIGHUP. It blocks until shutdown completes.
+func runServer(configFile string, initial Config) error {
+	liveRoutes.Store(newRouteTable(initial.Routes))
+	setTrustedProxies(initial.TrustedProxies)
+
+	shutdownTimeout := defaultShutdownTimeout
+	if initial.ShutdownTimeout != "" {
+		d, err := time.ParseDuration(initial.ShutdownTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid shutdown_timeout: %s", err)
+		}
+		shutdownTimeout = d
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/._cas/callbacks", adminCallbacksHandler(deliverer))
+	mux.Handle("/", handlerFuncRoutes(&liveRoutes))
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", *port),
+		Handler: mux,
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	for {
+		select {
+		case err := <-serveErr:
+			if err != nil && err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP {
+				reloadConfig(configFile)
+				continue
+			}
+			return shutdownServer(srv, shutdownTimeout)
+		}
+	}
+}
+
+// shutdownServer stops the listener, then waits up to timeout for
+// in-flight command executions to finish before returning.
+func shutdownServer(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		execWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Warn().Msg("shutdown timeout reached with commands still running")
+	}
+
+	return nil
+}
+
+// reloadConfig re-reads and validates configFile, then atomically
+// publishes the new route table and limiters so new requests see them
+// while in-flight ones finish against the Cmd they already captured.
+func reloadConfig(configFile string) {
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		logger.Error().Err(err).Msg("reload: failed to read config file")
+		return
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		logger.Error().Err(err).Msg("reload: failed to parse config file")
+		return
+	}
+
+	old := liveRoutes.Load().routes
+	logRouteDiff(old, config.Routes)
+
+	liveRoutes.Store(newRouteTable(config.Routes))
+	setTrustedProxies(config.TrustedProxies)
+}
+
+// logRouteDiff summarizes which routes were added, removed, or changed
+// by a reload.
+func logRouteDiff(old, updated map[string]Cmd) {
+	var added, removed, changed []string
+
+	for pattern, c := range updated {
+		oc, ok := old[pattern]
+		if !ok {
+			added = append(added, pattern)
+		} else if !reflect.DeepEqual(oc, c) {
+			changed = append(changed, pattern)
+		}
+	}
+	for pattern := range old {
+		if _, ok := updated[pattern]; !ok {
+			removed = append(removed, pattern)
+		}
+	}
+
+	logger.Info().
+		Strs("added", added).
+		Strs("removed", removed).
+		Strs("changed", changed).
+		Msg("config reloaded")
+}
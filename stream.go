@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// streamFrame is a single frame emitted when a Cmd streams its output:
+// either an "stdout"/"stderr" chunk, or the final "exit" frame.
+type streamFrame struct {
+	Stream   string `json:"stream"`
+	Data     string `json:"data,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// wantsStream reports whether this invocation should stream output rather
+// than buffer it: either the Cmd opts in via `stream: true`, or the
+// client asked for it with an Accept header.
+func wantsStream(r *http.Request, c Cmd) bool {
+	if c.Stream {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/event-stream") || strings.Contains(accept, "application/x-ndjson")
+}
+
+// streamResult runs c and pipes its stdout/stderr to w as they are
+// produced, as SSE events or newline-delimited JSON frames depending on
+// the Accept header, flushing after every chunk. Client disconnects (and
+// the route's timeout) cancel r.Context(), which kills the child's whole
+// process group via exec.CommandContext.
+func streamResult(w http.ResponseWriter, r *http.Request, c Cmd) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleError(w, r, errors.New("streaming unsupported by response writer"), http.StatusInternalServerError)
+		return
+	}
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	cmd := exec.CommandContext(r.Context(), c.Command, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Envs
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	if c.Stdin != "" {
+		cmd.Stdin = strings.NewReader(c.Stdin)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		handleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		handleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		writeFrame(w, sse, streamFrame{Stream: "exit", ExitCode: -1, Data: err.Error()})
+		flusher.Flush()
+		return
+	}
+
+	// w/flusher are shared by both goroutines below; mu serializes their
+	// writes since http.ResponseWriter isn't safe for concurrent use.
+	var mu sync.Mutex
+
+	stdoutDone := make(chan struct{})
+	stderrDone := make(chan struct{})
+	go pipeStream(w, flusher, &mu, sse, "stdout", stdout, r, stdoutDone)
+	go pipeStream(w, flusher, &mu, sse, "stderr", stderr, r, stderrDone)
+	<-stdoutDone
+	<-stderrDone
+
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else {
+			exitCode = -1
+		}
+	}
+
+	writeFrame(w, sse, streamFrame{Stream: "exit", ExitCode: exitCode})
+	flusher.Flush()
+
+	auditInvocation(auditLogger, r, c, runResult{Duration: time.Since(start), ExitCode: exitCode})
+}
+
+// maxStreamLineSize bounds how long a single streamed line may be.
+// bufio.Scanner's default 64KiB cap is easy for a single unbroken line
+// (base64, minified JSON, ...) to exceed, which makes Scan() stop as if
+// the stream ended cleanly while the child may still be writing to a
+// pipe nobody is draining — hanging cmd.Wait(), and the whole response,
+// until the route's timeout fires (or forever on routes with none).
+const maxStreamLineSize = 4 << 20 // 4MiB
+
+// pipeStream copies pr line by line into frames on w, flushing after
+// each one (serialized by mu, since stdout and stderr are piped by two
+// concurrent goroutines sharing one ResponseWriter), and closes done
+// when pr is exhausted. A line exceeding maxStreamLineSize or any other
+// scan error is reported as a frame instead of being dropped silently;
+// afterwards pr is drained to EOF so the child isn't left blocked
+// writing to a pipe nobody is reading.
+func pipeStream(w http.ResponseWriter, flusher http.Flusher, mu *sync.Mutex, sse bool, name string, pr io.Reader, req *http.Request, done chan<- struct{}) {
+	defer close(done)
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLineSize)
+	for scanner.Scan() {
+		mu.Lock()
+		writeFrame(w, sse, streamFrame{Stream: name, Data: scanner.Text()})
+		flusher.Flush()
+		mu.Unlock()
+	}
+
+	if err := scanner.Err(); err != nil {
+		hlog.FromRequest(req).Error().Str("stream", name).Err(err).Msg("stream scan error")
+
+		mu.Lock()
+		writeFrame(w, sse, streamFrame{Stream: name, Data: fmt.Sprintf("stream error: %s", err)})
+		flusher.Flush()
+		mu.Unlock()
+
+		io.Copy(io.Discard, pr)
+	}
+}
+
+func writeFrame(w http.ResponseWriter, sse bool, f streamFrame) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	if sse {
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", f.Stream, b)
+		return
+	}
+	w.Write(append(b, '\n'))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamNdjson(t *testing.T) {
+	config := map[string]Cmd{
+		"/echo": Cmd{
+			Command: "echo",
+			Args:    []string{"hello"},
+			Stream:  true,
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp := req(t, server.URL+"/echo", nil)
+	expectStatus(t, resp, 200)
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Expect content type application/x-ndjson, but got %q", ct)
+	}
+
+	var frames []streamFrame
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var f streamFrame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+
+	if len(frames) != 2 {
+		t.Fatalf("Expect 2 frames, but got %d: %+v", len(frames), frames)
+	}
+	if frames[0].Stream != "stdout" || frames[0].Data != "hello" {
+		t.Fatalf("Expect stdout frame %q, but got %+v", "hello", frames[0])
+	}
+	if frames[1].Stream != "exit" || frames[1].ExitCode != 0 {
+		t.Fatalf("Expect exit frame with code 0, but got %+v", frames[1])
+	}
+}
+
+// TestStreamLineOverflows64KiB guards against a regression to
+// bufio.Scanner's default 64KiB token limit, which used to make a
+// single long line look like a clean end of stream.
+func TestStreamLineOverflows64KiB(t *testing.T) {
+	long := strings.Repeat("x", 70*1024)
+
+	config := map[string]Cmd{
+		"/echo": Cmd{
+			Command: "echo",
+			Args:    []string{"-n", long},
+			Stream:  true,
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp := req(t, server.URL+"/echo", nil)
+	expectStatus(t, resp, 200)
+
+	var frames []streamFrame
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLineSize)
+	for scanner.Scan() {
+		var f streamFrame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+
+	if len(frames) != 2 {
+		t.Fatalf("Expect 2 frames, but got %d", len(frames))
+	}
+	if frames[0].Stream != "stdout" || frames[0].Data != long {
+		t.Fatalf("Expect full %d-byte stdout line, got %d bytes", len(long), len(frames[0].Data))
+	}
+	if frames[1].Stream != "exit" || frames[1].ExitCode != 0 {
+		t.Fatalf("Expect exit frame with code 0, but got %+v", frames[1])
+	}
+}
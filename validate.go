@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// checkRequestParam enforces both the coarse Allow list and, when a Schema
+// is configured, the richer per-parameter rules: max counts, RE2 patterns,
+// enums, max lengths, and for envs a variable name whitelist. It returns
+// the first constraint violated so the 403 response can name it.
+func checkRequestParam(r *http.Request, c Cmd, p Param) error {
+	if !c.isAllowed("args") && len(p.Args) > 0 {
+		return errors.New("args param is not allowed")
+	}
+	if !c.isAllowed("envs") && len(p.Envs) > 0 {
+		return errors.New("envs param is not allowed")
+	}
+	if !c.isAllowed("stdin") && p.Stdin != "" {
+		return errors.New("stdin param is not allowed")
+	}
+	if !c.isAllowed("dir") && p.Dir != "" {
+		return errors.New("dir param is not allowed")
+	}
+	if !c.isAllowed("callback") && p.Callback != "" {
+		return errors.New("callback param is not allowed")
+	}
+
+	if err := c.Schema.Args.check(p.Args); err != nil {
+		return err
+	}
+	if err := c.Schema.Envs.check(p.Envs); err != nil {
+		return err
+	}
+	if err := c.Schema.Dir.check("dir", p.Dir); err != nil {
+		return err
+	}
+	if err := c.Schema.Stdin.check("stdin", p.Stdin); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// errSchema wraps a Schema violation found in a templated path/query
+// value (see bindCmdParams), distinguishing it from other bindCmdParams
+// errors like a malformed template so the caller can respond 403
+// instead of 400.
+type errSchema struct{ error }
+
+func (s *ArgSchema) check(args []string) error {
+	if s == nil {
+		return nil
+	}
+	if s.MaxCount > 0 && len(args) > s.MaxCount {
+		return fmt.Errorf("args: at most %d allowed, got %d", s.MaxCount, len(args))
+	}
+
+	for i, a := range args {
+		if s.MaxLength > 0 && len(a) > s.MaxLength {
+			return fmt.Errorf("args[%d]: exceeds max_length %d", i, s.MaxLength)
+		}
+		if len(s.Enum) > 0 && !stringInSlice(a, s.Enum) {
+			return fmt.Errorf("args[%d]: %q is not in the allowed enum", i, a)
+		}
+
+		pattern := s.Pattern
+		if i < len(s.Patterns) {
+			pattern = s.Patterns[i]
+		}
+		if pattern == "" {
+			continue
+		}
+		matched, err := regexp.MatchString(pattern, a)
+		if err != nil {
+			return fmt.Errorf("args[%d]: invalid pattern %q: %s", i, pattern, err)
+		}
+		if !matched {
+			return fmt.Errorf("args[%d]: %q does not match pattern %q", i, a, pattern)
+		}
+	}
+
+	return nil
+}
+
+// checkValue validates a single templated arg value against the
+// schema's per-value rules (max_length, enum, pattern). It skips
+// MaxCount, which bounds how many extra args a caller may append and
+// doesn't apply to an individual value.
+func (s *ArgSchema) checkValue(value string) error {
+	if s == nil {
+		return nil
+	}
+	if s.MaxLength > 0 && len(value) > s.MaxLength {
+		return fmt.Errorf("templated arg: exceeds max_length %d", s.MaxLength)
+	}
+	if len(s.Enum) > 0 && !stringInSlice(value, s.Enum) {
+		return fmt.Errorf("templated arg: %q is not in the allowed enum", value)
+	}
+	if s.Pattern == "" {
+		return nil
+	}
+	matched, err := regexp.MatchString(s.Pattern, value)
+	if err != nil {
+		return fmt.Errorf("templated arg: invalid pattern %q: %s", s.Pattern, err)
+	}
+	if !matched {
+		return fmt.Errorf("templated arg: %q does not match pattern %q", value, s.Pattern)
+	}
+
+	return nil
+}
+
+func (s *EnvSchema) check(envs []string) error {
+	if s == nil {
+		return nil
+	}
+	if s.MaxCount > 0 && len(envs) > s.MaxCount {
+		return fmt.Errorf("envs: at most %d allowed, got %d", s.MaxCount, len(envs))
+	}
+
+	for _, e := range envs {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("envs: %q is not in NAME=VALUE form", e)
+		}
+		name, value := parts[0], parts[1]
+
+		if len(s.Names) > 0 && !stringInSlice(name, s.Names) {
+			return fmt.Errorf("envs: %q is not in the allowed variable names", name)
+		}
+		if s.MaxLength > 0 && len(value) > s.MaxLength {
+			return fmt.Errorf("envs: %s exceeds max_length %d", name, s.MaxLength)
+		}
+		if len(s.Enum) > 0 && !stringInSlice(value, s.Enum) {
+			return fmt.Errorf("envs: %s=%q is not in the allowed enum", name, value)
+		}
+		if s.Pattern == "" {
+			continue
+		}
+		matched, err := regexp.MatchString(s.Pattern, value)
+		if err != nil {
+			return fmt.Errorf("envs: invalid pattern %q: %s", s.Pattern, err)
+		}
+		if !matched {
+			return fmt.Errorf("envs: %s=%q does not match pattern %q", name, value, s.Pattern)
+		}
+	}
+
+	return nil
+}
+
+// checkValue validates a single templated "NAME=VALUE" env entry
+// against the schema's per-value rules, skipping MaxCount for the same
+// reason as ArgSchema.checkValue.
+func (s *EnvSchema) checkValue(entry string) error {
+	if s == nil {
+		return nil
+	}
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("templated env: %q is not in NAME=VALUE form", entry)
+	}
+	name, value := parts[0], parts[1]
+
+	if len(s.Names) > 0 && !stringInSlice(name, s.Names) {
+		return fmt.Errorf("templated env: %q is not in the allowed variable names", name)
+	}
+	if s.MaxLength > 0 && len(value) > s.MaxLength {
+		return fmt.Errorf("templated env: %s exceeds max_length %d", name, s.MaxLength)
+	}
+	if len(s.Enum) > 0 && !stringInSlice(value, s.Enum) {
+		return fmt.Errorf("templated env: %s=%q is not in the allowed enum", name, value)
+	}
+	if s.Pattern == "" {
+		return nil
+	}
+	matched, err := regexp.MatchString(s.Pattern, value)
+	if err != nil {
+		return fmt.Errorf("templated env: invalid pattern %q: %s", s.Pattern, err)
+	}
+	if !matched {
+		return fmt.Errorf("templated env: %s=%q does not match pattern %q", name, value, s.Pattern)
+	}
+
+	return nil
+}
+
+func (s *ValueSchema) check(name, value string) error {
+	if s == nil || value == "" {
+		return nil
+	}
+	if s.MaxLength > 0 && len(value) > s.MaxLength {
+		return fmt.Errorf("%s: exceeds max_length %d", name, s.MaxLength)
+	}
+	if len(s.Enum) > 0 && !stringInSlice(value, s.Enum) {
+		return fmt.Errorf("%s: %q is not in the allowed enum", name, value)
+	}
+	if s.Pattern != "" {
+		matched, err := regexp.MatchString(s.Pattern, value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %s", name, s.Pattern, err)
+		}
+		if !matched {
+			return fmt.Errorf("%s: %q does not match pattern %q", name, value, s.Pattern)
+		}
+	}
+
+	return nil
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
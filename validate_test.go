@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSchemaArgsPattern(t *testing.T) {
+	config := map[string]Cmd{
+		"/hello": Cmd{
+			Command: "echo",
+			Allow:   []string{"args"},
+			Schema: ParamSchema{
+				Args: &ArgSchema{
+					MaxCount: 1,
+					Pattern:  `^[a-z]+$`,
+				},
+			},
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp := req(t, server.URL+"/hello", bytes.NewBuffer([]byte(`{"args": ["world"]}`)))
+	expectStatus(t, resp, 200)
+	expectResult(t, resp, Result{Output: "world\n", Error: "", Status: 200})
+
+	resp = req(t, server.URL+"/hello", bytes.NewBuffer([]byte(`{"args": ["world", "again"]}`)))
+	expectStatus(t, resp, 403)
+
+	resp = req(t, server.URL+"/hello", bytes.NewBuffer([]byte(`{"args": ["rm -rf /"]}`)))
+	expectStatus(t, resp, 403)
+}
+
+func TestSchemaEnvsNames(t *testing.T) {
+	config := map[string]Cmd{
+		"/env": Cmd{
+			Command: "env",
+			Allow:   []string{"envs"},
+			Schema: ParamSchema{
+				Envs: &EnvSchema{
+					Names: []string{"FOO"},
+				},
+			},
+		},
+	}
+
+	server := httptest.NewServer(handlerFunc(config))
+	defer server.Close()
+
+	resp := req(t, server.URL+"/env", bytes.NewBuffer([]byte(`{"envs": ["FOO=bar"]}`)))
+	expectStatus(t, resp, 200)
+
+	resp = req(t, server.URL+"/env", bytes.NewBuffer([]byte(`{"envs": ["PATH=/tmp"]}`)))
+	expectStatus(t, resp, 403)
+	expectResult(t, resp, Result{
+		Output: "",
+		Error:  `envs: "PATH" is not in the allowed variable names`,
+		Status: 403,
+	})
+}